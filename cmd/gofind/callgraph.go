@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"sort"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/vta"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+var (
+	flagCallers = flag.Bool("callers", false, "Find callers of the target function, transitively")
+	flagCallees = flag.Bool("callees", false, "Find callees of the target function, transitively")
+	flagDepth   = flag.Int("depth", 0, "Limit call graph traversal to N levels (0 means unlimited)")
+	flagPrecise = flag.Bool("precise", false, "Use VTA (more precise, slower) instead of CHA to build the call graph")
+)
+
+// findTargetFunc locates the *types.Func denoted by pkgPath, objName and
+// selName among pkgs and their transitive imports. selName, when non-empty,
+// names a method of the type objName rather than a package-level function.
+func findTargetFunc(pkgs []*packages.Package, pkgPath, objName, selName string) *types.Func {
+	seen := map[*packages.Package]bool{}
+	var pkg *packages.Package
+
+	var visit func(p *packages.Package)
+	visit = func(p *packages.Package) {
+		if seen[p] || pkg != nil {
+			return
+		}
+		seen[p] = true
+		if p.Types != nil && p.Types.Path() == pkgPath {
+			pkg = p
+			return
+		}
+		for _, imp := range p.Imports {
+			visit(imp)
+		}
+	}
+	for _, p := range pkgs {
+		visit(p)
+	}
+	if pkg == nil {
+		return nil
+	}
+
+	obj := pkg.Types.Scope().Lookup(objName)
+	if obj == nil {
+		return nil
+	}
+
+	if selName == "" {
+		fn, _ := obj.(*types.Func)
+		return fn
+	}
+
+	m, _, _ := types.LookupFieldOrMethod(obj.Type(), true, pkg.Types, selName)
+	fn, _ := m.(*types.Func)
+	return fn
+}
+
+// runCallgraph implements the -callers/-callees modes: it builds an SSA
+// program and call graph for pkgs, locates the ssa.Function matching the
+// target, and prints each call edge reachable within -depth levels.
+func runCallgraph(pkgs []*packages.Package, pkgPath, objName, selName string) {
+	targetFunc := findTargetFunc(pkgs, pkgPath, objName, selName)
+	if targetFunc == nil {
+		log.Fatalf("could not find function %s", flag.Arg(0))
+	}
+
+	prog, _ := ssautil.AllPackages(pkgs, 0)
+	prog.Build()
+
+	ssaTarget := prog.FuncValue(targetFunc)
+	if ssaTarget == nil {
+		log.Fatalf("could not build SSA for function %s", flag.Arg(0))
+	}
+
+	var cg *callgraph.Graph
+	if *flagPrecise {
+		hash := cha.CallGraph(prog)
+		cg = vta.CallGraph(ssautil.AllFunctions(prog), hash)
+	} else {
+		cg = cha.CallGraph(prog)
+	}
+
+	node := cg.Nodes[ssaTarget]
+	if node == nil {
+		log.Fatalf("%s: not reachable in the call graph", flag.Arg(0))
+	}
+
+	kind := "caller"
+	if *flagCallees {
+		kind = "callee"
+	}
+
+	edges := walkCallgraph(node, prog.Fset, *flagCallees, *flagDepth, inScopeFunc(pkgs))
+
+	printEdges(edges, kind)
+}
+
+// inScopeFunc returns a predicate reporting whether fn belongs to one of
+// the initially loaded (root) packages, as opposed to a package pulled in
+// only as a dependency. walkCallgraph uses it to stop expanding the call
+// graph once it leaves the packages the user asked about.
+func inScopeFunc(pkgs []*packages.Package) func(fn *ssa.Function) bool {
+	rootPkgs := map[*types.Package]bool{}
+	for _, p := range pkgs {
+		if p.Types != nil {
+			rootPkgs[p.Types] = true
+		}
+	}
+	return func(fn *ssa.Function) bool {
+		return fn != nil && fn.Pkg != nil && rootPkgs[fn.Pkg.Pkg]
+	}
+}
+
+// walkCallgraph walks cg from node, following In edges (useCallees=false,
+// i.e. -callers) or Out edges (useCallees=true, i.e. -callees) up to depth
+// levels (0 means unlimited), and returns every distinct call edge
+// encountered, sorted by source position.
+//
+// seen dedups by the full (pos, caller, callee) triple, not just pos: CHA
+// resolves an interface-dispatched call site to one edge per candidate
+// concrete callee, so several distinct edges legitimately share a
+// position. Deduping on pos alone would keep only one such edge,
+// arbitrarily, since map iteration order over n.In/n.Out is randomized.
+// visited is separate: it controls node expansion (cycle/depth), not
+// which edges are kept.
+//
+// inScope bounds expansion to the packages the caller asked about: CHA is
+// unsound for first-class function values (it conservatively links any
+// func()-shaped value to every place a func() is invoked indirectly, e.g.
+// via goroutines or timers), so once the walk reaches a std-lib or
+// runtime function, continuing to follow its edges explodes into
+// thousands of irrelevant results. The boundary-crossing edge itself is
+// still reported; the walk just doesn't expand past it.
+func walkCallgraph(node *callgraph.Node, fset *token.FileSet, useCallees bool, depth int, inScope func(fn *ssa.Function) bool) []edgeResult {
+	type edgeKey struct {
+		pos    token.Pos
+		caller *callgraph.Node
+		callee *callgraph.Node
+	}
+	seen := map[edgeKey]bool{}
+	var edges []edgeResult
+
+	var walk func(n *callgraph.Node, d int, visited map[*callgraph.Node]bool)
+	walk = func(n *callgraph.Node, d int, visited map[*callgraph.Node]bool) {
+		if visited[n] {
+			return
+		}
+		visited[n] = true
+		if depth > 0 && d > depth {
+			return
+		}
+
+		callgraphEdges := n.In
+		if useCallees {
+			callgraphEdges = n.Out
+		}
+
+		for _, edge := range callgraphEdges {
+			if edge.Site == nil {
+				continue
+			}
+
+			key := edgeKey{edge.Site.Pos(), edge.Caller, edge.Callee}
+			if !seen[key] {
+				seen[key] = true
+				edges = append(edges, edgeResult{
+					pos:    fset.Position(key.pos),
+					caller: edge.Caller.Func.String(),
+					callee: edge.Callee.Func.String(),
+				})
+			}
+
+			next := edge.Caller
+			if useCallees {
+				next = edge.Callee
+			}
+			if inScope(next.Func) {
+				walk(next, d+1, visited)
+			}
+		}
+	}
+
+	walk(node, 1, map[*callgraph.Node]bool{})
+
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.pos.Filename != b.pos.Filename {
+			return a.pos.Filename < b.pos.Filename
+		}
+		if a.pos.Offset != b.pos.Offset {
+			return a.pos.Offset < b.pos.Offset
+		}
+		return a.callee < b.callee
+	})
+
+	return edges
+}
+
+// edgeResult is one call graph edge surfaced by -callers/-callees.
+type edgeResult struct {
+	pos    token.Position
+	caller string
+	callee string
+}
+
+func printEdges(edges []edgeResult, kind string) {
+	if *flagJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range edges {
+			match := jsonMatch{
+				File:    simplifyFilename(e.pos.Filename),
+				Line:    e.pos.Line,
+				Col:     e.pos.Column,
+				EndLine: e.pos.Line,
+				EndCol:  e.pos.Column,
+				Kind:    kind,
+				Text:    fmt.Sprintf("%s -> %s", e.caller, e.callee),
+				Object:  e.callee,
+			}
+			if err := enc.Encode(match); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	for _, e := range edges {
+		fmt.Printf("%s:%d: %s -> %s\n", simplifyFilename(e.pos.Filename), e.pos.Line, e.caller, e.callee)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// fixtureSource declares three types implementing a common interface and a
+// function that calls through it, so CHA resolves the call site to three
+// distinct candidate callees.
+const fixtureSource = `package fixture
+
+type Fooer interface{ Foo() int }
+
+type A struct{}
+func (A) Foo() int { return 1 }
+
+type B struct{}
+func (B) Foo() int { return 2 }
+
+type C struct{}
+func (C) Foo() int { return 3 }
+
+func call(f Fooer) int {
+	return f.Foo()
+}
+
+func main() {
+	_ = call(A{})
+	_ = call(B{})
+	_ = call(C{})
+}
+`
+
+// loadCallgraphFixtureNode writes fixtureSource to a temp module, loads it
+// via packages.Load, and returns the CHA call-graph node for "call" along
+// with the loaded root packages (for building an inScope predicate).
+func loadCallgraphFixtureNode(t *testing.T) (*callgraph.Node, []*packages.Package) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module fixture\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(fixtureSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &packages.Config{Mode: loadMode, Dir: dir, Tests: false}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		t.Fatal("fixture package failed to load")
+	}
+
+	targetFunc := findTargetFunc(pkgs, "fixture", "call", "")
+	if targetFunc == nil {
+		t.Fatal("could not find fixture.call")
+	}
+
+	prog, _ := ssautil.AllPackages(pkgs, 0)
+	prog.Build()
+
+	ssaTarget := prog.FuncValue(targetFunc)
+	if ssaTarget == nil {
+		t.Fatal("could not build SSA for fixture.call")
+	}
+
+	cg := cha.CallGraph(prog)
+	node := cg.Nodes[ssaTarget]
+	if node == nil {
+		t.Fatal("fixture.call not reachable in the call graph")
+	}
+	return node, pkgs
+}
+
+// TestWalkCallgraphKeepsPolymorphicEdges guards against a regression where
+// edges were deduped by source position alone: a single interface-dispatched
+// call site (f.Foo() above) resolves under CHA to one edge per candidate
+// concrete callee, all sharing that position. Deduping on position alone
+// kept only one arbitrary candidate, varying between runs because map
+// iteration over callgraph.Node.Out is randomized.
+func TestWalkCallgraphKeepsPolymorphicEdges(t *testing.T) {
+	node, pkgs := loadCallgraphFixtureNode(t)
+	inScope := inScopeFunc(pkgs)
+
+	// CHA resolves f.Foo() to both the value and pointer method of each of
+	// A, B, C: (A).Foo, (*A).Foo, (B).Foo, (*B).Foo, (C).Foo, (*C).Foo.
+	const wantCallees = 6
+	var first []edgeResult
+	for i := 0; i < 5; i++ {
+		edges := walkCallgraph(node, node.Func.Prog.Fset, true, 1, inScope)
+		if len(edges) != wantCallees {
+			t.Fatalf("run %d: got %d distinct callee edges, want %d: %v", i, len(edges), wantCallees, edges)
+		}
+		if i == 0 {
+			first = edges
+			continue
+		}
+		for j, e := range edges {
+			if e != first[j] {
+				t.Fatalf("run %d: edge %d = %+v, run 0 had %+v (non-deterministic output)", i, j, e, first[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// finding is a single match produced by one of the search passes, tagged
+// with the kind of occurrence it represents so a Formatter can describe it.
+type finding struct {
+	node ast.Node
+	kind string
+	pkg  *packages.Package
+}
+
+// Formatter renders a batch of findings to w. It is called once with all
+// buffered findings (already sorted by position) in the default mode, or
+// once per finding, in arrival order, when -stream is set.
+type Formatter interface {
+	Format(w io.Writer, fset *token.FileSet, findings []finding)
+}
+
+func sortFindings(fset *token.FileSet, findings []finding) {
+	sort.Slice(findings, func(i, j int) bool {
+		p := fset.Position(findings[i].node.Pos())
+		q := fset.Position(findings[j].node.Pos())
+		if p.Filename == q.Filename {
+			return p.Offset < q.Offset
+		}
+		return p.Filename < q.Filename
+	})
+}
+
+// fileCache reads and caches source files so Formatters don't re-read the
+// same file for every match on it.
+type fileCache struct {
+	data map[string][]byte
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{data: map[string][]byte{}}
+}
+
+func (c *fileCache) bytes(filename string) []byte {
+	if b, ok := c.data[filename]; ok {
+		return b
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.data[filename] = b
+	return b
+}
+
+func (c *fileCache) lines(filename string) [][]byte {
+	return bytes.Split(c.bytes(filename), []byte{'\n'})
+}
+
+// TextFormatter reproduces gofind's original ANSI-highlighted output,
+// merging findings that fall on the same line into one printed line with
+// several highlighted spans.
+type TextFormatter struct {
+	files *fileCache
+}
+
+func NewTextFormatter() *TextFormatter {
+	return &TextFormatter{files: newFileCache()}
+}
+
+func (t *TextFormatter) Format(w io.Writer, fset *token.FileSet, findings []finding) {
+	type highlight struct {
+		start int
+		end   int
+	}
+	type lineMatch struct {
+		filename   string
+		line       int
+		highlights []highlight
+	}
+
+	var (
+		matches []*lineMatch
+		curr    *lineMatch
+	)
+	for _, f := range findings {
+		p := fset.Position(f.node.Pos())
+		hl := highlight{p.Column - 1, p.Column - 1 + int(f.node.End()-f.node.Pos())}
+		if curr != nil && p.Filename == curr.filename && p.Line == curr.line {
+			curr.highlights = append(curr.highlights, hl)
+		} else {
+			curr = &lineMatch{filename: p.Filename, line: p.Line, highlights: []highlight{hl}}
+			matches = append(matches, curr)
+		}
+	}
+
+	for _, m := range matches {
+		lines := t.files.lines(m.filename)
+		line := lines[m.line-1]
+
+		var (
+			buf bytes.Buffer
+			pos int
+		)
+		for _, hl := range m.highlights {
+			fmt.Fprintf(&buf, "%s\x1b[31m%s\x1b[0m", line[pos:hl.start], line[hl.start:hl.end])
+			pos = hl.end
+		}
+		fmt.Fprintf(&buf, "%s", line[pos:])
+
+		fmt.Fprintf(w, "%s:%d:%s\n", simplifyFilename(m.filename), m.line, buf.String())
+	}
+}
+
+// JSONFormatter emits one JSON object per finding (JSON Lines), for
+// consumption by editor plugins and other tooling, analogous to `guru -json`.
+type JSONFormatter struct {
+	files *fileCache
+}
+
+func NewJSONFormatter() *JSONFormatter {
+	return &JSONFormatter{files: newFileCache()}
+}
+
+type jsonMatch struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	EndLine int    `json:"end_line"`
+	EndCol  int    `json:"end_col"`
+	Kind    string `json:"kind"`
+	Text    string `json:"text"`
+	Package string `json:"package,omitempty"`
+	Object  string `json:"object,omitempty"`
+}
+
+func (j *JSONFormatter) Format(w io.Writer, fset *token.FileSet, findings []finding) {
+	enc := json.NewEncoder(w)
+	for _, f := range findings {
+		start := fset.Position(f.node.Pos())
+		end := fset.Position(f.node.End())
+
+		var object, pkgPath string
+		if f.pkg != nil {
+			pkgPath = f.pkg.PkgPath
+			if ident, ok := f.node.(*ast.Ident); ok && f.pkg.TypesInfo != nil {
+				if obj := f.pkg.TypesInfo.ObjectOf(ident); obj != nil {
+					object = types.ObjectString(obj, types.RelativeTo(f.pkg.Types))
+				}
+			}
+		}
+
+		match := jsonMatch{
+			File:    simplifyFilename(start.Filename),
+			Line:    start.Line,
+			Col:     start.Column,
+			EndLine: end.Line,
+			EndCol:  end.Column,
+			Kind:    f.kind,
+			Text:    j.text(start, end),
+			Package: pkgPath,
+			Object:  object,
+		}
+		if err := enc.Encode(match); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// text returns the source snippet spanning [start, end), which for every
+// finding gofind produces lies on a single line.
+func (j *JSONFormatter) text(start, end token.Position) string {
+	line := j.files.lines(start.Filename)[start.Line-1]
+	if start.Column-1 > len(line) || end.Column-1 > len(line) {
+		return ""
+	}
+	return string(line[start.Column-1 : end.Column-1])
+}
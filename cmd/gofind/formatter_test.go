@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestJSONFormatterStreamMatchesBatch guards -json/-stream: JSONFormatter
+// must emit the same JSON Lines output whether it's called once per
+// finding, in arrival order (as main does under -stream), or once with the
+// whole sorted batch (the default).
+func TestJSONFormatterStreamMatchesBatch(t *testing.T) {
+	pkgs := loadSearchFixture(t)
+	findings := collectFindings(pkgs, "fixture", "Fooer", "", true)
+	if len(findings) == 0 {
+		t.Fatal("fixture produced no findings")
+	}
+	fset := pkgs[0].Fset
+
+	var batch bytes.Buffer
+	NewJSONFormatter().Format(&batch, fset, findings)
+
+	var streamed bytes.Buffer
+	streamFormatter := NewJSONFormatter()
+	for _, f := range findings {
+		streamFormatter.Format(&streamed, fset, []finding{f})
+	}
+
+	if batch.String() != streamed.String() {
+		t.Fatalf("batch output differs from streamed output:\nbatch:    %q\nstreamed: %q", batch.String(), streamed.String())
+	}
+
+	dec := json.NewDecoder(&batch)
+	var got int
+	for {
+		var m jsonMatch
+		if err := dec.Decode(&m); err != nil {
+			break
+		}
+		got++
+		if m.Kind == "" {
+			t.Errorf("match %d: kind is empty", got)
+		}
+		if m.File == "" {
+			t.Errorf("match %d: file is empty", got)
+		}
+	}
+	if got != len(findings) {
+		t.Fatalf("decoded %d JSON lines, want %d", got, len(findings))
+	}
+}
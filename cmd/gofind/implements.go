@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var flagImplements = flag.Bool("implements", false, "Find types implementing the target interface, or interfaces the target type implements")
+
+// findNamedType locates the *types.TypeName denoted by pkgPath and objName
+// among pkgs and their transitive imports.
+func findNamedType(pkgs []*packages.Package, pkgPath, objName string) *types.TypeName {
+	seen := map[*packages.Package]bool{}
+	var pkg *packages.Package
+
+	var visit func(p *packages.Package)
+	visit = func(p *packages.Package) {
+		if seen[p] || pkg != nil {
+			return
+		}
+		seen[p] = true
+		if p.Types != nil && p.Types.Path() == pkgPath {
+			pkg = p
+			return
+		}
+		for _, imp := range p.Imports {
+			visit(imp)
+		}
+	}
+	for _, p := range pkgs {
+		visit(p)
+	}
+	if pkg == nil {
+		return nil
+	}
+
+	tn, _ := pkg.Types.Scope().Lookup(objName).(*types.TypeName)
+	return tn
+}
+
+// findImplementations searches pkg for named types on either side of an
+// "implements" relationship with the -implements target:
+//
+//   - if the target is an interface (iface != nil), it reports concrete
+//     types in pkg that implement it;
+//   - if the target is a concrete type (named != nil, iface == nil), it
+//     reports interfaces declared in pkg that the target implements.
+//
+// When selName is given and the target is an interface, the method
+// declaration satisfying selName is also reported for each implementation.
+func findImplementations(pkg *packages.Package, named *types.Named, iface *types.Interface, selName string, c chan<- finding) {
+	for ident, obj := range pkg.TypesInfo.Defs {
+		tn, ok := obj.(*types.TypeName)
+		if !ok {
+			continue
+		}
+		candidate, ok := tn.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+		candidateIface, candidateIsIface := candidate.Underlying().(*types.Interface)
+
+		if iface != nil {
+			if candidateIsIface || !implementsEither(candidate, iface) {
+				continue
+			}
+
+			debugf("implements: found %v", ident)
+			c <- finding{ident, "implements", pkg}
+
+			if selName != "" {
+				m, _, _ := types.LookupFieldOrMethod(candidate, true, pkg.Types, selName)
+				if fn, ok := m.(*types.Func); ok {
+					if declIdent := findObjIdent(pkg, fn); declIdent != nil {
+						debugf("implements: found method %v", declIdent)
+						c <- finding{declIdent, "method", pkg}
+					}
+				}
+			}
+		} else {
+			if !candidateIsIface || !implementsEither(named, candidateIface) {
+				continue
+			}
+
+			debugf("implements: found %v", ident)
+			c <- finding{ident, "implements", pkg}
+		}
+	}
+}
+
+// implementsEither reports whether T or *T implements iface.
+func implementsEither(T *types.Named, iface *types.Interface) bool {
+	return types.Implements(T, iface) || types.Implements(types.NewPointer(T), iface)
+}
+
+// findObjIdent finds the *ast.Ident declaring obj within pkg.
+func findObjIdent(pkg *packages.Package, obj types.Object) *ast.Ident {
+	for ident, o := range pkg.TypesInfo.Defs {
+		if o == obj {
+			return ident
+		}
+	}
+	return nil
+}
@@ -18,19 +18,24 @@
 // * Variable definitions/occurrences
 // * Struct fields (with <sel>)
 // * Methods (with <sel>)
+// * Return types of calls
+// * Interface-typed struct fields, and the values assigned to them
 //
-// TODO(motemen): Find return types
+// When the target names a function or method, -callers and -callees switch
+// gofind to call graph mode, listing call sites reachable from (or leading
+// to) that function instead of searching for type-based occurrences.
+//
+// -json switches output to JSON Lines, one object per finding, for
+// consumption by editors and other tooling; -stream formats each finding
+// as it is found instead of buffering and sorting the whole result set.
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 
@@ -40,33 +45,9 @@ import (
 	"go/token"
 	"go/types"
 
-	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/packages"
 )
 
-type result struct {
-	fset  *token.FileSet
-	nodes []ast.Node
-}
-
-func (r result) Len() int {
-	return len(r.nodes)
-}
-
-func (r result) Less(i, j int) bool {
-	p := r.fset.Position(r.nodes[i].Pos())
-	q := r.fset.Position(r.nodes[j].Pos())
-
-	if p.Filename == q.Filename {
-		return p.Offset < q.Offset
-	} else {
-		return p.Filename < q.Filename
-	}
-}
-
-func (r result) Swap(i, j int) {
-	r.nodes[i], r.nodes[j] = r.nodes[j], r.nodes[i]
-}
-
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [-p] [-s] [-q] <pkg>.<name>[.<sel>] <args>\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, `
@@ -79,16 +60,31 @@ Example:
    % gofind -s encoding/json.Encoder.Encode $(go list golang.org/x/...)
    handlers.go:145:        json.NewEncoder(w).Encode(resp)
    socket.go:125:                  if err := enc.Encode(m); err != nil {`)
-	fmt.Fprintln(os.Stderr, loader.FromArgsUsage)
+	fmt.Fprintln(os.Stderr, `
+Packages can also be specified using the "./..." pattern, as in "go build",
+and gofind works both inside and outside of GOPATH as long as the target
+module has a go.mod file.`)
 }
 
 var (
 	flagFullpath = flag.Bool("p", false, "Print full filepaths")
 	flagSimple   = flag.Bool("s", false, "Print simple filenames")
 	flagQuiet    = flag.Bool("q", false, "Do not show errors")
+	flagJSON     = flag.Bool("json", false, "Emit JSON Lines output instead of highlighted text")
+	flagStream   = flag.Bool("stream", false, "Format each result as it is found instead of buffering and sorting")
 	hasLocalPkg  bool
 )
 
+const loadMode = packages.NeedName |
+	packages.NeedFiles |
+	packages.NeedSyntax |
+	packages.NeedTypes |
+	packages.NeedTypesInfo |
+	packages.NeedDeps |
+	packages.NeedImports |
+	packages.NeedTypesSizes |
+	packages.NeedModule
+
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("gofind: ")
@@ -133,10 +129,6 @@ func main() {
 		}
 	*/
 
-	var conf loader.Config
-	conf.AllowErrors = true
-	conf.TypeChecker.Error = func(_ error) {}
-
 	args := flag.Args()[1:]
 	for _, a := range args {
 		if strings.HasSuffix(a, ".go") || strings.HasPrefix(a, "./") || strings.HasPrefix(a, "."+string(filepath.Separator)) {
@@ -145,21 +137,99 @@ func main() {
 		}
 	}
 
-	_, err := conf.FromArgs(args, false)
-	if err != nil {
-		log.Fatal(err)
+	fset := token.NewFileSet()
+	cfg := &packages.Config{
+		Mode:  loadMode,
+		Fset:  fset,
+		Tests: false,
 	}
 
-	prog, err := conf.Load()
+	pkgs, err := packages.Load(cfg, args...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fieldMatches := func(typ types.Type, sel string) bool {
-		if sel != selName {
-			return false
+	if *flagCallers || *flagCallees {
+		if *flagCallers && *flagCallees {
+			log.Fatal("-callers and -callees are mutually exclusive")
+		}
+		runCallgraph(pkgs, pkgPath, objName, selName)
+		return
+	}
+
+	if *flagImplements {
+		tn := findNamedType(pkgs, pkgPath, objName)
+		if tn == nil {
+			log.Fatalf("could not find type %s.%s", pkgPath, objName)
 		}
+		if _, ok := tn.Type().(*types.Named); !ok {
+			log.Fatalf("could not find type %s.%s", pkgPath, objName)
+		}
+	}
 
+	var formatter Formatter
+	if *flagJSON {
+		formatter = NewJSONFormatter()
+	} else {
+		formatter = NewTextFormatter()
+	}
+
+	c := make(chan finding)
+	var findings []finding
+
+	done := make(chan struct{})
+	go func() {
+		for f := range c {
+			if *flagStream {
+				formatter.Format(os.Stdout, fset, []finding{f})
+			} else {
+				findings = append(findings, f)
+			}
+		}
+		done <- struct{}{}
+	}()
+
+	runSearch(pkgs, pkgPath, objName, selName, *flagImplements, c)
+
+	<-done
+
+	if !*flagStream {
+		sortFindings(fset, findings)
+		formatter.Format(os.Stdout, fset, findings)
+	}
+}
+
+// runSearch finds every occurrence of pkgPath.objName[.selName] across
+// pkgs and sends a finding for each to c, closing c once all passes have
+// completed. It covers:
+//
+//   - selections and uses/defs matching the target type (or, with
+//     selName, a field/method of that name on the target type)
+//   - return types of calls, when selName is empty
+//   - struct fields (keyed or positional) named selName on the target
+//     type, when selName is set
+//   - positional composite-literal fields assigning a value into an
+//     interface-typed field, when the target is itself an interface
+//   - implementations of the target interface, or interfaces the target
+//     type implements, when implements is true
+func runSearch(pkgs []*packages.Package, pkgPath, objName, selName string, implements bool, c chan<- finding) {
+	// targetNamed/targetIface describe pkgPath.objName when it names a
+	// declared type, used by -implements and by the interface-typed
+	// composite-literal field search below. targetIface is non-nil only
+	// when the target type is itself an interface.
+	var (
+		targetNamed *types.Named
+		targetIface *types.Interface
+	)
+	if tn := findNamedType(pkgs, pkgPath, objName); tn != nil {
+		if named, ok := tn.Type().(*types.Named); ok {
+			targetNamed = named
+			targetIface, _ = named.Underlying().(*types.Interface)
+		}
+	}
+
+	// matchesTarget reports whether typ (or *typ) names pkgPath.objName.
+	matchesTarget := func(typ types.Type) bool {
 		for {
 			if p, ok := typ.(*types.Pointer); ok {
 				typ = p.Elem()
@@ -181,35 +251,32 @@ func main() {
 		return tn.Obj().Pkg().Path() == pkgPath && tn.Obj().Name() == objName
 	}
 
-	c := make(chan ast.Node)
-	res := result{
-		fset:  conf.Fset,
-		nodes: []ast.Node{},
-	}
-
-	done := make(chan struct{})
-	go func() {
-		for node := range c {
-			res.nodes = append(res.nodes, node)
+	fieldMatches := func(typ types.Type, sel string) bool {
+		if sel != selName {
+			return false
 		}
-		done <- struct{}{}
-	}()
+		return matchesTarget(typ)
+	}
 
 	var wg sync.WaitGroup
 
 	// TODO(motemen): print for each package?
-	for _, pi := range prog.InitialPackages() {
-		if len(pi.Errors) != 0 {
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) != 0 {
 			if *flagQuiet == false {
-				if len(pi.Errors) == 1 {
-					log.Printf("%s: %s", pi.Pkg.Name(), pi.Errors[0])
+				if len(pkg.Errors) == 1 {
+					log.Printf("%s: %s", pkg.Name, pkg.Errors[0])
 				} else {
-					log.Printf("%s: %s and %d error(s)", pi.Pkg.Name(), pi.Errors[0], len(pi.Errors)-1)
+					log.Printf("%s: %s and %d error(s)", pkg.Name, pkg.Errors[0], len(pkg.Errors)-1)
 				}
 			}
 			continue
 		}
 
+		if pkg.Module != nil && pkg.Module.Dir != "" {
+			moduleDirs[pkg.Module.Dir] = true
+		}
+
 		// Find selections e.g.
 		//
 		//   % gofind -s encoding/json.Encoder.Encode golang.org/x/tools/cmd/godoc
@@ -221,25 +288,25 @@ func main() {
 		//                               ^^^^
 		//
 		wg.Add(1)
-		go func(pi *loader.PackageInfo) {
+		go func(pkg *packages.Package) {
 			defer wg.Done()
 
-			for expr, sel := range pi.Selections {
+			for expr, sel := range pkg.TypesInfo.Selections {
 				if v, ok := sel.Obj().(*types.Var); ok {
 					if fieldMatches(sel.Recv(), v.Name()) {
 						debugf("sel: found %v", expr.Sel)
-						c <- expr.Sel
+						c <- finding{expr.Sel, "selection", pkg}
 					}
 				} else if f, ok := sel.Obj().(*types.Func); ok {
 					if fieldMatches(sel.Recv(), f.Name()) {
 						debugf("sel: found %v", expr.Sel)
-						c <- expr.Sel
+						c <- finding{expr.Sel, "selection", pkg}
 					}
 				} else {
 					panic("unreachable")
 				}
 			}
-		}(pi)
+		}(pkg)
 
 		// Find functions and types e.g.
 		//
@@ -251,42 +318,99 @@ func main() {
 		//   client.go:84:5:var DefaultClient = &Client{}
 		//                      ^^^^^^^^^^^^^
 		wg.Add(1)
-		go func(pi *loader.PackageInfo) {
+		go func(pkg *packages.Package) {
 			defer wg.Done()
 
-			for ident, obj := range pi.Uses {
+			for ident, obj := range pkg.TypesInfo.Uses {
 				// do not include &TypeName{ ... } to simplify results
 				if _, isTypeName := obj.(*types.TypeName); isTypeName {
 					continue
 				} else if funcType, ok := obj.(*types.Func); ok {
 					if funcType.Pkg() != nil && funcType.Pkg().Path() == pkgPath && funcType.Name() == objName {
 						debugf("use: found %v", ident)
-						c <- ident
+						c <- finding{ident, "use", pkg}
 						continue
 					}
 				}
 
 				if fieldMatches(obj.Type(), "") {
 					debugf("use: found %v", ident)
-					c <- ident
+					c <- finding{ident, "use", pkg}
 				}
 			}
-		}(pi)
+		}(pkg)
 
 		wg.Add(1)
-		go func(pi *loader.PackageInfo) {
+		go func(pkg *packages.Package) {
 			defer wg.Done()
 
-			for ident, obj := range pi.Defs {
+			for ident, obj := range pkg.TypesInfo.Defs {
 				if obj == nil {
 					continue
 				}
 				if fieldMatches(obj.Type(), "") {
 					debugf("def: found %v")
-					c <- ident
+					c <- finding{ident, "def", pkg}
 				}
 			}
-		}(pi)
+		}(pkg)
+
+		// Find calls whose return type matches the target, e.g.
+		//
+		//   % gofind -s net/http.Request net/http
+		//   client.go:634:14:       req, err := http.NewRequest("GET", url, nil)
+		//                                            ^^^^^^^^^^
+		//
+		// This covers the "find return types" gap noted above: the call's
+		// result tuple (or, for single-valued calls, its plain result type)
+		// is checked for the target type, and the call's function
+		// expression is reported.
+		if selName == "" {
+			wg.Add(1)
+			go func(pkg *packages.Package) {
+				defer wg.Done()
+
+				for expr, tv := range pkg.TypesInfo.Types {
+					call, ok := expr.(*ast.CallExpr)
+					if !ok {
+						continue
+					}
+					if _, ok := pkg.TypesInfo.TypeOf(call.Fun).(*types.Signature); !ok {
+						// type conversion, e.g. T(x), not a call
+						continue
+					}
+
+					matched := false
+					if tuple, ok := tv.Type.(*types.Tuple); ok {
+						for i := 0; i < tuple.Len(); i++ {
+							if matchesTarget(tuple.At(i).Type()) {
+								matched = true
+								break
+							}
+						}
+					} else {
+						matched = matchesTarget(tv.Type)
+					}
+					if !matched {
+						continue
+					}
+
+					var ident *ast.Ident
+					switch fun := call.Fun.(type) {
+					case *ast.Ident:
+						ident = fun
+					case *ast.SelectorExpr:
+						ident = fun.Sel
+					}
+					if ident == nil {
+						continue
+					}
+
+					debugf("return: found %v", ident)
+					c <- finding{ident, "return", pkg}
+				}
+			}(pkg)
+		}
 
 		// find values inside composite literals with values without keys e.g.:
 		//
@@ -295,11 +419,11 @@ func main() {
 		//                                                              ^^^^^^^
 		if selName != "" {
 			wg.Add(1)
-			go func(pi *loader.PackageInfo) {
+			go func(pkg *packages.Package) {
 				defer wg.Done()
 
 			typeExprs:
-				for expr, tv := range pi.Types {
+				for expr, tv := range pkg.TypesInfo.Types {
 					comp, ok := expr.(*ast.CompositeLit)
 					if !ok || len(comp.Elts) == 0 {
 						continue
@@ -320,7 +444,7 @@ func main() {
 							kv := elt.(*ast.KeyValueExpr)
 							if kv.Key.(*ast.Ident).Name == selName {
 								debugf("field: found %v", kv.Key)
-								c <- kv.Key
+								c <- finding{kv.Key, "field", pkg}
 								continue typeExprs
 							}
 						}
@@ -331,82 +455,95 @@ func main() {
 						for i, elt := range comp.Elts {
 							if st.Field(i).Name() == selName {
 								debugf("field: found %v", elt)
-								c <- elt
+								c <- finding{elt, "field", pkg}
 								continue typeExprs
 							}
 						}
 					}
 				}
-			}(pi)
+			}(pkg)
 		}
-	}
-
-	wg.Wait()
 
-	close(c)
+		// When the target is an interface, find positional composite
+		// literals that assign a value to an interface-typed struct
+		// field, tracking where concrete values flow into interface
+		// fields, e.g.
+		//
+		//   % gofind -s io.Writer golang.org/x/tools/cmd/godoc
+		//   main.go:40:18:  h := &handler{os.Stdout}
+		//                                 ^^^^^^^^^
+		//
+		// Keyed composite literals (handler{w: os.Stdout}) are already
+		// covered above: the key identifier resolves to the field's
+		// *types.Var via TypesInfo.Uses, so the generic Uses-based search
+		// matches it whenever the field's type is the target interface.
+		if selName == "" && targetIface != nil {
+			wg.Add(1)
+			go func(pkg *packages.Package) {
+				defer wg.Done()
 
-	<-done
+				for expr, tv := range pkg.TypesInfo.Types {
+					comp, ok := expr.(*ast.CompositeLit)
+					if !ok || len(comp.Elts) == 0 {
+						continue
+					}
+					if _, isKV := comp.Elts[0].(*ast.KeyValueExpr); isKV {
+						continue
+					}
 
-	sort.Sort(res)
+					st, ok := tv.Type.Underlying().(*types.Struct)
+					if !ok || st.NumFields() != len(comp.Elts) {
+						continue
+					}
 
-	// print results
+					for i, elt := range comp.Elts {
+						if _, ok := st.Field(i).Type().Underlying().(*types.Interface); !ok {
+							continue
+						}
 
-	type highlight struct {
-		start int
-		end   int
-	}
-	type result struct {
-		filename   string
-		line       int
-		highlights []highlight
-	}
-	var (
-		results = []*result{}
-		curr    *result
-	)
-	for _, n := range res.nodes {
-		p := conf.Fset.Position(n.Pos())
-		hl := highlight{p.Column - 1, p.Column - 1 + int(n.End()-n.Pos())}
-		if curr != nil && p.Filename == curr.filename && p.Line == curr.line {
-			curr.highlights = append(curr.highlights, hl)
-		} else {
-			curr = &result{
-				filename:   p.Filename,
-				line:       p.Line,
-				highlights: []highlight{hl},
-			}
-			results = append(results, curr)
+						eltType := pkg.TypesInfo.TypeOf(elt)
+						for {
+							if p, ok := eltType.(*types.Pointer); ok {
+								eltType = p.Elem()
+							} else {
+								break
+							}
+						}
+						if types.Implements(eltType, targetIface) || types.Implements(types.NewPointer(eltType), targetIface) {
+							debugf("field: found %v", elt)
+							c <- finding{elt, "field", pkg}
+						}
+					}
+				}
+			}(pkg)
 		}
-	}
-
-	fileLines := map[string][][]byte{}
-	for _, result := range results {
-		lines := fileLines[result.filename]
-		if lines == nil {
-			b, err := ioutil.ReadFile(result.filename)
-			if err != nil {
-				log.Fatal(err)
-			}
 
-			lines = bytes.Split(b, []byte{'\n'})
-			fileLines[result.filename] = lines
+		// Find implementations of an interface, or the interfaces a
+		// concrete type satisfies, e.g.
+		//
+		//   % gofind -implements -s io.Reader golang.org/x/tools/cmd/godoc
+		//   fs.go:42:6:     type httpFileSystem struct {
+		//                        ^^^^^^^^^^^^^^^
+		//
+		if implements {
+			wg.Add(1)
+			go func(pkg *packages.Package) {
+				defer wg.Done()
+				findImplementations(pkg, targetNamed, targetIface, selName, c)
+			}(pkg)
 		}
+	}
 
-		line := lines[result.line-1]
-		var (
-			hlBuf bytes.Buffer
-			pos   int
-		)
-		for _, hl := range result.highlights {
-			fmt.Fprintf(&hlBuf, "%s\x1b[31m%s\x1b[0m", line[pos:hl.start], line[hl.start:hl.end])
-			pos = hl.end
-		}
-		fmt.Fprintf(&hlBuf, "%s", line[pos:])
+	wg.Wait()
 
-		fmt.Printf("%s:%d:%s\n", simplifyFilename(result.filename), result.line, hlBuf.String())
-	}
+	close(c)
 }
 
+// moduleDirs collects the root directories of modules encountered while
+// loading packages, so that simplifyFilename can shorten paths relative
+// to them in addition to the traditional GOPATH src directories.
+var moduleDirs = map[string]bool{}
+
 func simplifyFilename(filename string) string {
 	if *flagFullpath {
 		return filename
@@ -417,6 +554,9 @@ func simplifyFilename(filename string) string {
 
 	simple := filename
 	srcDirs := build.Default.SrcDirs()
+	for d := range moduleDirs {
+		srcDirs = append(srcDirs, d)
+	}
 	if hasLocalPkg {
 		if wd, err := os.Getwd(); err == nil {
 			srcDirs = append(srcDirs, wd)
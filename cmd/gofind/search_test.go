@@ -0,0 +1,138 @@
+package main
+
+import (
+	"go/ast"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// searchFixtureSource declares an interface, an implementing type, a struct
+// with an interface-typed field, and a function returning that type, so a
+// single fixture exercises -implements, the interface-field search, and the
+// return-type search.
+const searchFixtureSource = `package fixture
+
+type Fooer interface{ Foo() int }
+
+type A struct{}
+
+func (A) Foo() int { return 1 }
+
+type container struct {
+	f Fooer
+}
+
+func makeA() A { return A{} }
+
+func main() {
+	_ = container{A{}}
+	_ = makeA()
+}
+`
+
+// loadSearchFixture writes searchFixtureSource to a temp module and loads it
+// via packages.Load, returning the root packages for runSearch.
+func loadSearchFixture(t *testing.T) []*packages.Package {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module fixture\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(searchFixtureSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &packages.Config{Mode: loadMode, Dir: dir, Tests: false}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		t.Fatal("fixture package failed to load")
+	}
+	return pkgs
+}
+
+// collectFindings runs runSearch to completion and returns every finding it
+// produced.
+func collectFindings(pkgs []*packages.Package, pkgPath, objName, selName string, implements bool) []finding {
+	c := make(chan finding)
+	var findings []finding
+	done := make(chan struct{})
+	go func() {
+		for f := range c {
+			findings = append(findings, f)
+		}
+		done <- struct{}{}
+	}()
+	runSearch(pkgs, pkgPath, objName, selName, implements, c)
+	<-done
+	return findings
+}
+
+func findingKinds(findings []finding, kind string) []finding {
+	var out []finding
+	for _, f := range findings {
+		if f.kind == kind {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// TestRunSearchImplements guards the -implements pass: searching for
+// fixture.Fooer must report A as an implementation.
+func TestRunSearchImplements(t *testing.T) {
+	pkgs := loadSearchFixture(t)
+	findings := collectFindings(pkgs, "fixture", "Fooer", "", true)
+
+	implements := findingKinds(findings, "implements")
+	if len(implements) != 1 {
+		t.Fatalf("got %d implements findings, want 1: %v", len(implements), findings)
+	}
+	ident, ok := implements[0].node.(*ast.Ident)
+	if !ok || ident.Name != "A" {
+		t.Fatalf("implements finding = %v, want ident A", implements[0].node)
+	}
+}
+
+// TestRunSearchReturnType guards the return-type search pass: searching for
+// fixture.A must report makeA's call expression, since its result type is A.
+func TestRunSearchReturnType(t *testing.T) {
+	pkgs := loadSearchFixture(t)
+	findings := collectFindings(pkgs, "fixture", "A", "", false)
+
+	returns := findingKinds(findings, "return")
+	if len(returns) != 1 {
+		t.Fatalf("got %d return findings, want 1: %v", len(returns), findings)
+	}
+	ident, ok := returns[0].node.(*ast.Ident)
+	if !ok || ident.Name != "makeA" {
+		t.Fatalf("return finding = %v, want ident makeA", returns[0].node)
+	}
+}
+
+// TestRunSearchInterfaceField guards the interface-typed composite-literal
+// field search: searching for fixture.Fooer must report the A{} inside
+// container{A{}}, since A implements Fooer and is assigned into its
+// Fooer-typed field.
+func TestRunSearchInterfaceField(t *testing.T) {
+	pkgs := loadSearchFixture(t)
+	findings := collectFindings(pkgs, "fixture", "Fooer", "", false)
+
+	fields := findingKinds(findings, "field")
+	if len(fields) != 1 {
+		t.Fatalf("got %d field findings, want 1: %v", len(fields), findings)
+	}
+	comp, ok := fields[0].node.(*ast.CompositeLit)
+	if !ok {
+		t.Fatalf("field finding = %v, want *ast.CompositeLit", fields[0].node)
+	}
+	if ident, ok := comp.Type.(*ast.Ident); !ok || ident.Name != "A" {
+		t.Fatalf("field finding composite literal type = %v, want A", comp.Type)
+	}
+}